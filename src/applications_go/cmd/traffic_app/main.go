@@ -1,23 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	modeBulk  = "bulk"
-	modeOnOff = "onoff"
+	modeBulk    = "bulk"
+	modeOnOff   = "onoff"
+	modePoisson = "poisson"
+	modeMMPP    = "mmpp"
+)
+
+const (
+	reliabilityNone   = "none"
+	reliabilityUDPARQ = "udp-arq"
 )
 
 type throughputStats struct {
@@ -26,8 +40,233 @@ type throughputStats struct {
 }
 
 func (s *throughputStats) add(n int) {
-	s.packets++
-	s.bytes += int64(n)
+	s.addN(n, 1)
+}
+
+func (s *throughputStats) addN(bytes int, packets int) {
+	s.packets += int64(packets)
+	s.bytes += int64(bytes)
+}
+
+// traceEvent is one line of --trace-jsonl output describing a single packet
+// event on the send or sink side.
+type traceEvent struct {
+	TSNs   int64  `json:"ts_ns"`
+	Role   string `json:"role"`
+	Proto  string `json:"proto"`
+	FlowID uint32 `json:"flow_id"`
+	Seq    uint64 `json:"seq"`
+	Bytes  int    `json:"bytes"`
+	Event  string `json:"event"`
+}
+
+// tracer writes traceEvents as JSONL from a buffered goroutine so tracing
+// never blocks the send/receive hot path; events are dropped rather than
+// blocking if the channel fills up.
+type tracer struct {
+	ch   chan traceEvent
+	done chan struct{}
+	f    *os.File
+}
+
+func newTracer(path string) (*tracer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	w := os.Stdout
+	var f *os.File
+	if path != "-" {
+		var err error
+		f, err = os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	t := &tracer{
+		ch:   make(chan traceEvent, 4096),
+		done: make(chan struct{}),
+		f:    f,
+	}
+	go t.run(w)
+	return t, nil
+}
+
+func (t *tracer) run(w io.Writer) {
+	defer close(t.done)
+	enc := json.NewEncoder(w)
+	for ev := range t.ch {
+		_ = enc.Encode(ev)
+	}
+}
+
+func (t *tracer) emit(ev traceEvent) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.ch <- ev:
+	default:
+	}
+}
+
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	close(t.ch)
+	<-t.done
+	if t.f != nil {
+		_ = t.f.Close()
+	}
+}
+
+// owdBucketsSeconds are the romam_owd_seconds histogram bucket upper bounds
+// (Prometheus's own default buckets; one-way delay is expected to fall in
+// the same sub-second-to-low-seconds range as typical request latencies).
+var owdBucketsSeconds = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metricsLabels identifies one counter/gauge series exposed by --metrics-listen.
+type metricsLabels struct {
+	role   string
+	proto  string
+	flowID string
+}
+
+// metricsRegistry backs --metrics-listen: a small hand-rolled Prometheus
+// text-format exporter (the repo takes no third-party dependencies), fed by
+// report() and friends plus the sink's one-way-delay samples.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	packets  map[metricsLabels]int64
+	bytes    map[metricsLabels]int64
+	interval map[metricsLabels]float64
+
+	owdBuckets []int64
+	owdSum     float64
+	owdCount   int64
+
+	srv  *http.Server
+	done chan struct{}
+}
+
+func newMetrics(listen string) (*metricsRegistry, error) {
+	if listen == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	m := &metricsRegistry{
+		packets:    make(map[metricsLabels]int64),
+		bytes:      make(map[metricsLabels]int64),
+		interval:   make(map[metricsLabels]float64),
+		owdBuckets: make([]int64, len(owdBucketsSeconds)),
+		done:       make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handleMetrics)
+	m.srv = &http.Server{Handler: mux}
+	go func() {
+		defer close(m.done)
+		_ = m.srv.Serve(ln)
+	}()
+	fmt.Printf("metrics listening on http://%s/metrics\n", listen)
+	return m, nil
+}
+
+func (m *metricsRegistry) observe(labels metricsLabels, stats throughputStats, intervalS float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packets[labels] = stats.packets
+	m.bytes[labels] = stats.bytes
+	m.interval[labels] = intervalS
+}
+
+// observeOWD records a one-way-delay sample derived from an RMM1 sendTS.
+func (m *metricsRegistry) observeOWD(seconds float64) {
+	if m == nil || seconds < 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.owdSum += seconds
+	m.owdCount++
+	for i, bound := range owdBucketsSeconds {
+		if seconds <= bound {
+			m.owdBuckets[i]++
+			return
+		}
+	}
+}
+
+func (m *metricsRegistry) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeText(w)
+}
+
+func (m *metricsRegistry) writeText(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsLabels, 0, len(m.packets))
+	for k := range m.packets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].role != keys[j].role {
+			return keys[i].role < keys[j].role
+		}
+		if keys[i].proto != keys[j].proto {
+			return keys[i].proto < keys[j].proto
+		}
+		return keys[i].flowID < keys[j].flowID
+	})
+
+	fmt.Fprintln(w, "# HELP romam_packets_total Total packets observed for this role/proto/flow.")
+	fmt.Fprintln(w, "# TYPE romam_packets_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "romam_packets_total{role=%q,proto=%q,flow_id=%q} %d\n", k.role, k.proto, k.flowID, m.packets[k])
+	}
+
+	fmt.Fprintln(w, "# HELP romam_bytes_total Total bytes observed for this role/proto/flow.")
+	fmt.Fprintln(w, "# TYPE romam_bytes_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "romam_bytes_total{role=%q,proto=%q,flow_id=%q} %d\n", k.role, k.proto, k.flowID, m.bytes[k])
+	}
+
+	fmt.Fprintln(w, "# HELP romam_send_interval_seconds Seconds since the previous report for this role/proto/flow.")
+	fmt.Fprintln(w, "# TYPE romam_send_interval_seconds gauge")
+	for _, k := range keys {
+		fmt.Fprintf(w, "romam_send_interval_seconds{role=%q,proto=%q,flow_id=%q} %g\n", k.role, k.proto, k.flowID, m.interval[k])
+	}
+
+	fmt.Fprintln(w, "# HELP romam_owd_seconds One-way delay from the RMM1 send timestamp to receipt, in seconds.")
+	fmt.Fprintln(w, "# TYPE romam_owd_seconds histogram")
+	cumulative := int64(0)
+	for i, bound := range owdBucketsSeconds {
+		cumulative += m.owdBuckets[i]
+		fmt.Fprintf(w, "romam_owd_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "romam_owd_seconds_bucket{le=\"+Inf\"} %d\n", m.owdCount)
+	fmt.Fprintf(w, "romam_owd_seconds_sum %g\n", m.owdSum)
+	fmt.Fprintf(w, "romam_owd_seconds_count %d\n", m.owdCount)
+}
+
+// close shuts down the metrics HTTP server; called from run() once the
+// sink/send loop has returned via the same stop channel that ends the run.
+func (m *metricsRegistry) close() {
+	if m == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = m.srv.Shutdown(ctx)
+	<-m.done
 }
 
 type sinkOptions struct {
@@ -40,6 +279,11 @@ type sinkOptions struct {
 	rcvbufBytes     int
 	durationS       float64
 	startAfterS     float64
+	reliability     string
+	ackMS           float64
+	sackBits        int
+	traceJSONL      string
+	metricsListen   string
 }
 
 type sendOptions struct {
@@ -59,6 +303,19 @@ type sendOptions struct {
 	sndbufBytes     int
 	tcpNoDelay      bool
 	startAfterS     float64
+	reliability     string
+	initCwnd        int
+	minRTOms        float64
+	maxRetries      int
+	sackBits        int
+	traceJSONL      string
+	streams         int
+	seed            int64
+	mmppLambda1     float64
+	mmppLambda2     float64
+	mmppMean1MS     float64
+	mmppMean2MS     float64
+	metricsListen   string
 }
 
 func main() {
@@ -90,14 +347,33 @@ func run() int {
 		if !sleepStartDelay(opts.startAfterS, stop) {
 			return 0
 		}
+		tr, err := newTracer(opts.traceJSONL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		defer tr.close()
+		mr, err := newMetrics(opts.metricsListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		defer mr.close()
 		if opts.proto == "udp" {
-			if err := runUDPSink(opts, stop); err != nil {
+			if opts.reliability == reliabilityUDPARQ {
+				if err := runUDPSinkARQ(opts, stop, tr, mr); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					return 1
+				}
+				return 0
+			}
+			if err := runUDPSink(opts, stop, tr, mr); err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return 1
 			}
 			return 0
 		}
-		if err := runTCPSink(opts, stop); err != nil {
+		if err := runTCPSink(opts, stop, tr, mr); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			return 1
 		}
@@ -115,14 +391,33 @@ func run() int {
 		if !sleepStartDelay(opts.startAfterS, stop) {
 			return 0
 		}
+		tr, err := newTracer(opts.traceJSONL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		defer tr.close()
+		mr, err := newMetrics(opts.metricsListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		defer mr.close()
 		if opts.proto == "udp" {
-			if err := runUDPSend(opts, stop); err != nil {
+			if opts.reliability == reliabilityUDPARQ {
+				if err := runUDPSendARQ(opts, stop, tr, mr); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					return 1
+				}
+				return 0
+			}
+			if err := runUDPSend(opts, stop, tr, mr); err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return 1
 			}
 			return 0
 		}
-		if err := runTCPSend(opts, stop); err != nil {
+		if err := runTCPSend(opts, stop, tr, mr); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			return 1
 		}
@@ -194,6 +489,11 @@ func parseSinkArgs(args []string) (sinkOptions, error) {
 	fs.IntVar(&opts.rcvbufBytes, "rcvbuf-bytes", 0, "SO_RCVBUF")
 	fs.Float64Var(&opts.durationS, "duration-s", 0.0, "run duration")
 	fs.Float64Var(&opts.startAfterS, "start-after-s", 0.0, "startup delay")
+	fs.StringVar(&opts.reliability, "reliability", reliabilityNone, "none or udp-arq")
+	fs.Float64Var(&opts.ackMS, "ack-ms", 10.0, "ack interval in milliseconds (udp-arq)")
+	fs.IntVar(&opts.sackBits, "sack-bits", 32, "SACK bitmap width in bits (udp-arq)")
+	fs.StringVar(&opts.traceJSONL, "trace-jsonl", "", "write per-packet JSONL trace to file (or - for stdout)")
+	fs.StringVar(&opts.metricsListen, "metrics-listen", "", "HOST:PORT to expose Prometheus /metrics (empty disables)")
 
 	if err := fs.Parse(args); err != nil {
 		return sinkOptions{}, err
@@ -227,10 +527,19 @@ func parseSendArgs(args []string) (sendOptions, error) {
 	fs.IntVar(&opts.sndbufBytes, "sndbuf-bytes", 0, "SO_SNDBUF")
 	fs.BoolVar(&opts.tcpNoDelay, "tcp-nodelay", false, "enable TCP_NODELAY")
 	fs.Float64Var(&opts.startAfterS, "start-after-s", 0.0, "startup delay")
-
-	_ = fs.Int("seed", 0, "compat placeholder")
-	_ = fs.Int("stream-id", 0, "compat placeholder")
-	_ = fs.String("trace-jsonl", "", "compat placeholder")
+	fs.StringVar(&opts.reliability, "reliability", reliabilityNone, "none or udp-arq")
+	fs.IntVar(&opts.initCwnd, "init-cwnd", 4, "initial congestion window in packets (udp-arq)")
+	fs.Float64Var(&opts.minRTOms, "min-rto-ms", 200.0, "minimum retransmission timeout in milliseconds (udp-arq)")
+	fs.IntVar(&opts.maxRetries, "max-retries", 10, "max retransmits per packet before giving up (udp-arq)")
+	fs.IntVar(&opts.sackBits, "sack-bits", 32, "SACK bitmap width in bits (udp-arq)")
+	fs.StringVar(&opts.traceJSONL, "trace-jsonl", "", "write per-packet JSONL trace to file (or - for stdout)")
+	fs.IntVar(&opts.streams, "streams", 1, "number of parallel streams, each its own flow (base --flow-id + index)")
+	fs.Int64Var(&opts.seed, "seed", 0, "RNG seed for poisson/mmpp patterns (reproducible runs)")
+	fs.Float64Var(&opts.mmppLambda1, "mmpp-lambda1", 50.0, "state-1 rate in packets/sec (mmpp pattern)")
+	fs.Float64Var(&opts.mmppLambda2, "mmpp-lambda2", 5.0, "state-2 rate in packets/sec (mmpp pattern)")
+	fs.Float64Var(&opts.mmppMean1MS, "mmpp-mean1-ms", 500.0, "mean sojourn in state 1, milliseconds (mmpp pattern)")
+	fs.Float64Var(&opts.mmppMean2MS, "mmpp-mean2-ms", 500.0, "mean sojourn in state 2, milliseconds (mmpp pattern)")
+	fs.StringVar(&opts.metricsListen, "metrics-listen", "", "HOST:PORT to expose Prometheus /metrics (empty disables)")
 
 	if err := fs.Parse(args); err != nil {
 		return sendOptions{}, err
@@ -270,6 +579,23 @@ func validateSinkArgs(opts sinkOptions) error {
 	if opts.startAfterS < 0 {
 		return errors.New("--start-after-s must be >= 0")
 	}
+	if opts.reliability != reliabilityNone && opts.reliability != reliabilityUDPARQ {
+		return errors.New("--reliability must be none or udp-arq")
+	}
+	if opts.reliability == reliabilityUDPARQ && opts.proto != "udp" {
+		return errors.New("--reliability udp-arq requires --proto udp")
+	}
+	if opts.ackMS <= 0 {
+		return errors.New("--ack-ms must be > 0")
+	}
+	if opts.sackBits < 0 || opts.sackBits > 32 {
+		return errors.New("--sack-bits must be between 0 and 32")
+	}
+	if opts.metricsListen != "" {
+		if _, _, err := net.SplitHostPort(opts.metricsListen); err != nil {
+			return fmt.Errorf("--metrics-listen: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -286,6 +612,9 @@ func validateSendArgs(opts sendOptions) error {
 	if opts.packetSize <= 0 {
 		return errors.New("--packet-size must be > 0")
 	}
+	if opts.reliability == reliabilityUDPARQ && opts.packetSize < arqDataHeaderLen {
+		return fmt.Errorf("--packet-size must be >= %d with --reliability udp-arq", arqDataHeaderLen)
+	}
 	if opts.count < 0 {
 		return errors.New("--count must be >= 0")
 	}
@@ -295,8 +624,10 @@ func validateSendArgs(opts sendOptions) error {
 	if opts.pps < 0 {
 		return errors.New("--pps must be >= 0")
 	}
-	if opts.pattern != modeBulk && opts.pattern != modeOnOff {
-		return errors.New("--pattern must be bulk or onoff")
+	switch opts.pattern {
+	case modeBulk, modeOnOff, modePoisson, modeMMPP:
+	default:
+		return errors.New("--pattern must be bulk, onoff, poisson, or mmpp")
 	}
 	if opts.onMS < 0 {
 		return errors.New("--on-ms must be >= 0")
@@ -304,6 +635,17 @@ func validateSendArgs(opts sendOptions) error {
 	if opts.offMS < 0 {
 		return errors.New("--off-ms must be >= 0")
 	}
+	if opts.pattern == modePoisson && opts.pps <= 0 {
+		return errors.New("--pps must be > 0 for --pattern poisson")
+	}
+	if opts.pattern == modeMMPP {
+		if opts.mmppLambda1 <= 0 || opts.mmppLambda2 <= 0 {
+			return errors.New("--mmpp-lambda1 and --mmpp-lambda2 must be > 0 for --pattern mmpp")
+		}
+		if opts.mmppMean1MS <= 0 || opts.mmppMean2MS <= 0 {
+			return errors.New("--mmpp-mean1-ms and --mmpp-mean2-ms must be > 0 for --pattern mmpp")
+		}
+	}
 	if opts.reportIntervalS <= 0 {
 		return errors.New("--report-interval-s must be > 0")
 	}
@@ -316,10 +658,39 @@ func validateSendArgs(opts sendOptions) error {
 	if opts.startAfterS < 0 {
 		return errors.New("--start-after-s must be >= 0")
 	}
+	if opts.reliability != reliabilityNone && opts.reliability != reliabilityUDPARQ {
+		return errors.New("--reliability must be none or udp-arq")
+	}
+	if opts.reliability == reliabilityUDPARQ && opts.proto != "udp" {
+		return errors.New("--reliability udp-arq requires --proto udp")
+	}
+	if opts.reliability == reliabilityUDPARQ && opts.streams > 1 {
+		return errors.New("--streams > 1 is not supported with --reliability udp-arq")
+	}
+	if opts.initCwnd <= 0 {
+		return errors.New("--init-cwnd must be > 0")
+	}
+	if opts.minRTOms <= 0 {
+		return errors.New("--min-rto-ms must be > 0")
+	}
+	if opts.maxRetries <= 0 {
+		return errors.New("--max-retries must be > 0")
+	}
+	if opts.sackBits < 0 || opts.sackBits > 32 {
+		return errors.New("--sack-bits must be between 0 and 32")
+	}
+	if opts.streams <= 0 {
+		return errors.New("--streams must be > 0")
+	}
+	if opts.metricsListen != "" {
+		if _, _, err := net.SplitHostPort(opts.metricsListen); err != nil {
+			return fmt.Errorf("--metrics-listen: %v", err)
+		}
+	}
 	return nil
 }
 
-func runUDPSink(opts sinkOptions, stop <-chan struct{}) error {
+func runUDPSink(opts sinkOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
 	addr := net.JoinHostPort(opts.bind, strconv.Itoa(opts.port))
 	conn, err := net.ListenPacket("udp", addr)
 	if err != nil {
@@ -332,6 +703,7 @@ func runUDPSink(opts sinkOptions, stop <-chan struct{}) error {
 	}
 
 	stats := throughputStats{}
+	perFlow := make(map[uint32]*throughputStats)
 	start := time.Now()
 	last := start
 	interval := durationFromSeconds(opts.reportIntervalS)
@@ -357,17 +729,30 @@ func runUDPSink(opts sinkOptions, stop <-chan struct{}) error {
 			}
 		} else {
 			stats.add(n)
+			if hdr, ok := parsePayloadHeader(buf[:n]); ok {
+				flowStats(perFlow, hdr.flowID).add(n)
+				tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "sink", Proto: "udp", FlowID: hdr.flowID, Seq: hdr.seq, Bytes: n, Event: "recv"})
+				mr.observeOWD(float64(time.Now().UnixNano()-int64(hdr.sendTS)) / 1e9)
+			}
 		}
 		if time.Since(last) >= interval {
-			last = report("udp sink", start, last, stats)
+			prevLast := last
+			last = report("udp sink", start, last, stats, metricsLabels{role: "sink", proto: "udp", flowID: "all"}, mr)
+			reportPerFlow("udp sink", start, prevLast, perFlow, "sink", "udp", mr)
 		}
 	}
 
-	report("udp sink final", start, last, stats)
+	report("udp sink final", start, last, stats, metricsLabels{role: "sink", proto: "udp", flowID: "all"}, mr)
+	reportPerFlow("udp sink final", start, last, perFlow, "sink", "udp", mr)
 	return nil
 }
 
-func runTCPSink(opts sinkOptions, stop <-chan struct{}) error {
+// runTCPSink accepts TCP connections in a loop and services each one in its
+// own goroutine, so that `--streams N` on the sender side (N parallel TCP
+// connections) is actually read concurrently rather than one at a time; the
+// shared stats/perFlow counters are updated under mu, mirroring how
+// runUDPSink demultiplexes multiple flows over a single socket.
+func runTCPSink(opts sinkOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
 	addr := net.JoinHostPort(opts.bind, strconv.Itoa(opts.port))
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -375,87 +760,145 @@ func runTCPSink(opts sinkOptions, stop <-chan struct{}) error {
 	}
 	defer listener.Close()
 
+	var mu sync.Mutex
 	stats := throughputStats{}
+	perFlow := make(map[uint32]*throughputStats)
+	conns := make(map[net.Conn]struct{})
+
 	start := time.Now()
 	last := start
 	interval := durationFromSeconds(opts.reportIntervalS)
 	durationLimit := durationFromSeconds(opts.durationS)
+	tcpLabels := metricsLabels{role: "sink", proto: "tcp", flowID: "all"}
 
 	fmt.Printf("tcp sink listening on %s:%d\n", opts.bind, opts.port)
-	buf := make([]byte, opts.bufferSize)
-
-	for {
-		if isStopped(stop) {
-			break
-		}
-		now := time.Now()
-		if durationLimit > 0 && now.Sub(start) >= durationLimit {
-			break
-		}
 
-		if tcpListener, ok := listener.(*net.TCPListener); ok {
-			_ = tcpListener.SetDeadline(time.Now().Add(interval))
-		}
-		conn, err := listener.Accept()
-		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				if time.Since(last) >= interval {
-					last = report("tcp sink", start, last, stats)
-				}
-				continue
-			}
-			if errors.Is(err, net.ErrClosed) {
-				break
-			}
-			return err
-		}
+	doReport := func(prefix string) {
+		mu.Lock()
+		defer mu.Unlock()
+		prevLast := last
+		last = report(prefix, start, last, stats, tcpLabels, mr)
+		reportPerFlow(prefix, start, prevLast, perFlow, "sink", "tcp", mr)
+	}
 
+	var wg sync.WaitGroup
+	serve := func(conn net.Conn) {
+		defer wg.Done()
 		peer := conn.RemoteAddr().String()
 		fmt.Printf("tcp sink accepted peer=%s\n", peer)
 		if tcpConn, ok := conn.(*net.TCPConn); ok && opts.rcvbufBytes > 0 {
 			_ = tcpConn.SetReadBuffer(opts.rcvbufBytes)
 		}
+		defer func() {
+			_ = conn.Close()
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
 
+		buf := make([]byte, opts.bufferSize)
 		for {
 			if isStopped(stop) {
-				_ = conn.Close()
-				break
+				return
 			}
 			if durationLimit > 0 && time.Since(start) >= durationLimit {
-				_ = conn.Close()
-				break
+				return
 			}
 			_ = conn.SetReadDeadline(time.Now().Add(interval))
 			n, err := conn.Read(buf)
 			if err != nil {
 				if ne, ok := err.(net.Error); ok && ne.Timeout() {
-					if time.Since(last) >= interval {
-						last = report("tcp sink", start, last, stats)
-					}
 					continue
 				}
 				if errors.Is(err, io.EOF) {
 					fmt.Printf("tcp sink peer closed peer=%s\n", peer)
-					_ = conn.Close()
-					break
+					return
 				}
-				_ = conn.Close()
-				return err
+				return
 			}
 			if n > 0 {
+				hdr, ok := parsePayloadHeader(buf[:n])
+				mu.Lock()
 				stats.add(n)
+				if ok {
+					flowStats(perFlow, hdr.flowID).add(n)
+				}
+				mu.Unlock()
+				if ok {
+					tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "sink", Proto: "tcp", FlowID: hdr.flowID, Seq: hdr.seq, Bytes: n, Event: "recv"})
+					mr.observeOWD(float64(time.Now().UnixNano()-int64(hdr.sendTS)) / 1e9)
+				}
 			}
-			if time.Since(last) >= interval {
-				last = report("tcp sink", start, last, stats)
+		}
+	}
+
+	for {
+		if isStopped(stop) {
+			break
+		}
+		now := time.Now()
+		if durationLimit > 0 && now.Sub(start) >= durationLimit {
+			break
+		}
+
+		if tcpListener, ok := listener.(*net.TCPListener); ok {
+			_ = tcpListener.SetDeadline(time.Now().Add(interval))
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if time.Since(last) >= interval {
+					doReport("tcp sink")
+				}
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				break
 			}
+			return err
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go serve(conn)
+
+		if time.Since(last) >= interval {
+			doReport("tcp sink")
 		}
 	}
 
-	report("tcp sink final", start, last, stats)
+	mu.Lock()
+	for c := range conns {
+		_ = c.Close()
+	}
+	mu.Unlock()
+	wg.Wait()
+
+	doReport("tcp sink final")
 	return nil
 }
 
-func runUDPSend(opts sendOptions, stop <-chan struct{}) error {
+func runUDPSend(opts sendOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
+	fmt.Printf(
+		"udp send target=%s:%d packet_size=%d count=%d duration_s=%.3f pps=%.3f pattern=%s streams=%d\n",
+		opts.target,
+		opts.port,
+		opts.packetSize,
+		opts.count,
+		opts.durationS,
+		opts.pps,
+		opts.pattern,
+		opts.streams,
+	)
+	if opts.streams <= 1 {
+		return runUDPSendStream(opts, stop, tr, "udp", nil, mr)
+	}
+	return runMultiStreamSend(opts, stop, tr, "udp", runUDPSendStream, mr)
+}
+
+func runUDPSendStream(opts sendOptions, stop <-chan struct{}, tr *tracer, prefix string, agg *streamAggregate, mr *metricsRegistry) error {
 	target := net.JoinHostPort(opts.target, strconv.Itoa(opts.port))
 	raddr, err := net.ResolveUDPAddr("udp", target)
 	if err != nil {
@@ -470,9 +913,12 @@ func runUDPSend(opts sendOptions, stop <-chan struct{}) error {
 	if opts.sndbufBytes > 0 {
 		_ = conn.SetWriteBuffer(opts.sndbufBytes)
 	}
+	return runSendLoop(opts, stop, conn.Write, prefix, tr, agg, mr)
+}
 
+func runTCPSend(opts sendOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
 	fmt.Printf(
-		"udp send target=%s:%d packet_size=%d count=%d duration_s=%.3f pps=%.3f pattern=%s\n",
+		"tcp send target=%s:%d packet_size=%d count=%d duration_s=%.3f pps=%.3f pattern=%s streams=%d\n",
 		opts.target,
 		opts.port,
 		opts.packetSize,
@@ -480,11 +926,15 @@ func runUDPSend(opts sendOptions, stop <-chan struct{}) error {
 		opts.durationS,
 		opts.pps,
 		opts.pattern,
+		opts.streams,
 	)
-	return runSendLoop(opts, stop, conn.Write, "udp")
+	if opts.streams <= 1 {
+		return runTCPSendStream(opts, stop, tr, "tcp", nil, mr)
+	}
+	return runMultiStreamSend(opts, stop, tr, "tcp", runTCPSendStream, mr)
 }
 
-func runTCPSend(opts sendOptions, stop <-chan struct{}) error {
+func runTCPSendStream(opts sendOptions, stop <-chan struct{}, tr *tracer, prefix string, agg *streamAggregate, mr *metricsRegistry) error {
 	target := net.JoinHostPort(opts.target, strconv.Itoa(opts.port))
 	dialer := net.Dialer{Timeout: durationFromSeconds(opts.connectTimeoutS)}
 	connRaw, err := dialer.Dial("tcp", target)
@@ -501,18 +951,115 @@ func runTCPSend(opts sendOptions, stop <-chan struct{}) error {
 			_ = tcpConn.SetWriteBuffer(opts.sndbufBytes)
 		}
 	}
+	return runSendLoop(opts, stop, connRaw.Write, prefix, tr, agg, mr)
+}
 
-	fmt.Printf(
-		"tcp send connected target=%s:%d packet_size=%d count=%d duration_s=%.3f pps=%.3f pattern=%s\n",
-		opts.target,
-		opts.port,
-		opts.packetSize,
-		opts.count,
-		opts.durationS,
-		opts.pps,
-		opts.pattern,
-	)
-	return runSendLoop(opts, stop, connRaw.Write, "tcp")
+// streamAggregate combines per-stream throughput into one set of counters
+// for the --streams combined report line; updates come from concurrent
+// stream goroutines so it's accessed with atomics rather than a mutex.
+type streamAggregate struct {
+	packets int64
+	bytes   int64
+}
+
+func (a *streamAggregate) add(n int) {
+	if a == nil {
+		return
+	}
+	atomic.AddInt64(&a.packets, 1)
+	atomic.AddInt64(&a.bytes, int64(n))
+}
+
+func (a *streamAggregate) snapshot() throughputStats {
+	if a == nil {
+		return throughputStats{}
+	}
+	return throughputStats{packets: atomic.LoadInt64(&a.packets), bytes: atomic.LoadInt64(&a.bytes)}
+}
+
+// splitStreamOptions divides --pps and --count evenly across opts.streams
+// streams and assigns each its own flow id (base --flow-id + index) and, for
+// the poisson/mmpp patterns, its own RNG seed (base --seed + index) so the
+// streams don't all draw the identical inter-arrival sequence and burst in
+// lockstep.
+func splitStreamOptions(opts sendOptions) []sendOptions {
+	n := opts.streams
+	if n < 1 {
+		n = 1
+	}
+	perPPS := opts.pps / float64(n)
+	perCount := opts.count / int64(n)
+	remainder := opts.count % int64(n)
+
+	out := make([]sendOptions, n)
+	for i := 0; i < n; i++ {
+		so := opts
+		so.pps = perPPS
+		so.count = perCount
+		if int64(i) < remainder {
+			so.count++
+		}
+		so.flowID = opts.flowID + uint32(i)
+		so.seed = opts.seed + int64(i)
+		out[i] = so
+	}
+	return out
+}
+
+func runMultiStreamSend(
+	opts sendOptions,
+	stop <-chan struct{},
+	tr *tracer,
+	label string,
+	streamFn func(sendOptions, <-chan struct{}, *tracer, string, *streamAggregate, *metricsRegistry) error,
+	mr *metricsRegistry,
+) error {
+	streamOpts := splitStreamOptions(opts)
+	agg := &streamAggregate{}
+	start := time.Now()
+	interval := durationFromSeconds(opts.reportIntervalS)
+	combinedLabels := metricsLabels{role: "send", proto: label, flowID: "all"}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(streamOpts))
+	for i, so := range streamOpts {
+		wg.Add(1)
+		go func(i int, so sendOptions) {
+			defer wg.Done()
+			prefix := fmt.Sprintf("%s[s%d]", label, i)
+			if err := streamFn(so, stop, tr, prefix, agg, mr); err != nil {
+				errCh <- err
+			}
+		}(i, so)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	last := start
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+waitLoop:
+	for {
+		select {
+		case <-done:
+			break waitLoop
+		case <-ticker.C:
+			last = report(label+" send combined", start, last, agg.snapshot(), combinedLabels, mr)
+		}
+	}
+	report(label+" send combined final", start, last, agg.snapshot(), combinedLabels, mr)
+
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func runSendLoop(
@@ -520,6 +1067,9 @@ func runSendLoop(
 	stop <-chan struct{},
 	sendFn func([]byte) (int, error),
 	prefix string,
+	tr *tracer,
+	agg *streamAggregate,
+	mr *metricsRegistry,
 ) error {
 	stats := throughputStats{}
 	start := time.Now()
@@ -529,6 +1079,8 @@ func runSendLoop(
 
 	onDuration := durationFromSeconds(opts.onMS / 1000.0)
 	offDuration := durationFromSeconds(opts.offMS / 1000.0)
+	ps := newPatternState(opts)
+	labels := metricsLabels{role: "send", proto: opts.proto, flowID: strconv.FormatUint(uint64(opts.flowID), 10)}
 
 	nextSend := start
 	for {
@@ -561,45 +1113,122 @@ func runSendLoop(
 			}
 			time.Sleep(sleepFor)
 			if time.Since(last) >= interval {
-				last = report(prefix+" send", start, last, stats)
+				last = report(prefix+" send", start, last, stats, labels, mr)
 			}
 			continue
 		}
 
-		payload := buildPayload(opts.packetSize, opts.flowID, uint64(stats.packets+1))
+		seq := uint64(stats.packets + 1)
+		payload := buildPayload(opts.packetSize, opts.flowID, seq)
 		n, err := sendFn(payload)
 		if err != nil {
 			return err
 		}
 		stats.add(n)
+		agg.add(n)
+		tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "send", Proto: opts.proto, FlowID: opts.flowID, Seq: seq, Bytes: n, Event: "sent"})
 
-		if opts.pps > 0 {
-			nextSend = sendAt.Add(durationFromSeconds(1.0 / opts.pps))
-		} else {
-			nextSend = time.Now()
+		switch opts.pattern {
+		case modePoisson, modeMMPP:
+			nextSend = sendAt.Add(ps.nextInterArrival(opts, time.Now()))
+		default:
+			if opts.pps > 0 {
+				nextSend = sendAt.Add(durationFromSeconds(1.0 / opts.pps))
+			} else {
+				nextSend = time.Now()
+			}
 		}
 		if time.Since(last) >= interval {
-			last = report(prefix+" send", start, last, stats)
+			last = report(prefix+" send", start, last, stats, labels, mr)
 		}
 	}
 
-	report(prefix+" send final", start, last, stats)
+	report(prefix+" send final", start, last, stats, labels, mr)
 	return nil
 }
 
-func computeNextSendTime(
-	opts sendOptions,
-	start time.Time,
-	now time.Time,
-	nextSend time.Time,
-	onDuration time.Duration,
+// patternState carries the mutable state behind the poisson/mmpp patterns:
+// a seeded RNG plus, for mmpp, which of the two states is currently active
+// and when it's next due to flip.
+type patternState struct {
+	rng        *rand.Rand
+	mmppInit   bool
+	mmppState  int
+	mmppFlipAt time.Time
+}
+
+func newPatternState(opts sendOptions) *patternState {
+	return &patternState{rng: rand.New(rand.NewSource(opts.seed))}
+}
+
+// exponentialInterval draws -ln(U)/lambda for U uniform on 0 < U <= 1, the
+// standard inversion-sampling recipe for a Poisson inter-arrival time.
+func exponentialInterval(rng *rand.Rand, lambda float64) time.Duration {
+	if lambda <= 0 {
+		return 0
+	}
+	u := 1.0 - rng.Float64() // 0 < u <= 1, avoids ln(0)
+	return durationFromSeconds(-math.Log(u) / lambda)
+}
+
+func (ps *patternState) sojourn(opts sendOptions, state int) time.Duration {
+	meanMS := opts.mmppMean1MS
+	if state == 1 {
+		meanMS = opts.mmppMean2MS
+	}
+	if meanMS <= 0 {
+		return 0
+	}
+	return exponentialInterval(ps.rng, 1000.0/meanMS)
+}
+
+// mmppLambda advances the 2-state Markov-modulated Poisson process to the
+// given time (flipping state each time a sampled sojourn expires) and
+// returns the arrival rate for whichever state is now active.
+func (ps *patternState) mmppLambda(opts sendOptions, now time.Time) float64 {
+	if !ps.mmppInit {
+		ps.mmppState = 0
+		ps.mmppFlipAt = now.Add(ps.sojourn(opts, ps.mmppState))
+		ps.mmppInit = true
+	}
+	for !ps.mmppFlipAt.After(now) {
+		ps.mmppState = 1 - ps.mmppState
+		ps.mmppFlipAt = ps.mmppFlipAt.Add(ps.sojourn(opts, ps.mmppState))
+	}
+	if ps.mmppState == 0 {
+		return opts.mmppLambda1
+	}
+	return opts.mmppLambda2
+}
+
+func (ps *patternState) nextInterArrival(opts sendOptions, now time.Time) time.Duration {
+	switch opts.pattern {
+	case modePoisson:
+		return exponentialInterval(ps.rng, opts.pps)
+	case modeMMPP:
+		return exponentialInterval(ps.rng, ps.mmppLambda(opts, now))
+	default:
+		if opts.pps > 0 {
+			return durationFromSeconds(1.0 / opts.pps)
+		}
+		return 0
+	}
+}
+
+func computeNextSendTime(
+	opts sendOptions,
+	start time.Time,
+	now time.Time,
+	nextSend time.Time,
+	onDuration time.Duration,
 	offDuration time.Duration,
 ) (time.Time, bool) {
 	candidate := now
-	if opts.pps > 0 && nextSend.After(candidate) {
+	paced := opts.pps > 0 || opts.pattern == modePoisson || opts.pattern == modeMMPP
+	if paced && nextSend.After(candidate) {
 		candidate = nextSend
 	}
-	if opts.pattern == modeBulk {
+	if opts.pattern == modeBulk || opts.pattern == modePoisson || opts.pattern == modeMMPP {
 		return candidate, true
 	}
 	return clampToOnWindow(candidate, start, onDuration, offDuration)
@@ -658,7 +1287,647 @@ func buildPayload(packetSize int, flowID uint32, seq uint64) []byte {
 	return out
 }
 
-func report(prefix string, start time.Time, last time.Time, stats throughputStats) time.Time {
+type payloadHeader struct {
+	flowID uint32
+	seq    uint64
+	sendTS uint64
+}
+
+// parsePayloadHeader parses the fixed "RMM1" header written by buildPayload
+// so the sink can recover flow/seq/one-way-delay for tracing without
+// otherwise changing the plain (non-ARQ) wire format.
+func parsePayloadHeader(buf []byte) (payloadHeader, bool) {
+	const headerLen = 28
+	if len(buf) < headerLen || string(buf[0:4]) != "RMM1" {
+		return payloadHeader{}, false
+	}
+	return payloadHeader{
+		flowID: binary.BigEndian.Uint32(buf[4:8]),
+		seq:    binary.BigEndian.Uint64(buf[8:16]),
+		sendTS: binary.BigEndian.Uint64(buf[16:24]),
+	}, true
+}
+
+const (
+	arqMagic         = "RMM1"
+	arqFrameData     = byte(0)
+	arqFrameAck      = byte(1)
+	arqDataHeaderLen = 29 // magic(4) type(1) flowID(4) seq(8) sendTS(8) payloadLen(4)
+	arqAckHeaderLen  = 23 // magic(4) type(1) flowID(4) cumAck(8) sackBitmap(4) window(2)
+)
+
+type arqDataFrame struct {
+	flowID     uint32
+	seq        uint64
+	sendTS     uint64
+	payloadLen uint32
+}
+
+// buildARQDataFrame extends buildPayload's header with a frame-type byte so
+// data frames can be told apart from ack frames on the wire.
+func buildARQDataFrame(packetSize int, flowID uint32, seq uint64) []byte {
+	sendTS := uint64(time.Now().UnixNano())
+	if packetSize < arqDataHeaderLen {
+		packetSize = arqDataHeaderLen
+	}
+	payloadLen := packetSize - arqDataHeaderLen
+	out := make([]byte, packetSize)
+	copy(out[0:4], []byte(arqMagic))
+	out[4] = arqFrameData
+	binary.BigEndian.PutUint32(out[5:9], flowID)
+	binary.BigEndian.PutUint64(out[9:17], seq)
+	binary.BigEndian.PutUint64(out[17:25], sendTS)
+	binary.BigEndian.PutUint32(out[25:29], uint32(payloadLen))
+	for i := arqDataHeaderLen; i < len(out); i++ {
+		out[i] = 'x'
+	}
+	return out
+}
+
+func parseARQDataFrame(buf []byte) (arqDataFrame, bool) {
+	if len(buf) < arqDataHeaderLen || string(buf[0:4]) != arqMagic || buf[4] != arqFrameData {
+		return arqDataFrame{}, false
+	}
+	return arqDataFrame{
+		flowID:     binary.BigEndian.Uint32(buf[5:9]),
+		seq:        binary.BigEndian.Uint64(buf[9:17]),
+		sendTS:     binary.BigEndian.Uint64(buf[17:25]),
+		payloadLen: binary.BigEndian.Uint32(buf[25:29]),
+	}, true
+}
+
+type arqAckFrame struct {
+	flowID     uint32
+	cumAck     uint64
+	sackBitmap uint32
+	window     uint16
+}
+
+// buildARQAckFrame builds a cumulative ack plus a SACK bitmap covering the
+// next 32 seqs after cumAck (bit i set means cumAck+1+i was received).
+func buildARQAckFrame(flowID uint32, cumAck uint64, sackBitmap uint32, window uint16) []byte {
+	out := make([]byte, arqAckHeaderLen)
+	copy(out[0:4], []byte(arqMagic))
+	out[4] = arqFrameAck
+	binary.BigEndian.PutUint32(out[5:9], flowID)
+	binary.BigEndian.PutUint64(out[9:17], cumAck)
+	binary.BigEndian.PutUint32(out[17:21], sackBitmap)
+	binary.BigEndian.PutUint16(out[21:23], window)
+	return out
+}
+
+func parseARQAckFrame(buf []byte) (arqAckFrame, bool) {
+	if len(buf) < arqAckHeaderLen || string(buf[0:4]) != arqMagic || buf[4] != arqFrameAck {
+		return arqAckFrame{}, false
+	}
+	return arqAckFrame{
+		flowID:     binary.BigEndian.Uint32(buf[5:9]),
+		cumAck:     binary.BigEndian.Uint64(buf[9:17]),
+		sackBitmap: binary.BigEndian.Uint32(buf[17:21]),
+		window:     binary.BigEndian.Uint16(buf[21:23]),
+	}, true
+}
+
+type arqInflightPacket struct {
+	payload      []byte
+	sendTime     time.Time
+	retries      int
+	sackAdvances int
+}
+
+// arqSender tracks in-flight packets, RTT/RTO estimation, and an AIMD
+// congestion window for the udp-arq reliability mode.
+type arqSender struct {
+	mu         sync.Mutex
+	inflight   map[uint64]*arqInflightPacket
+	srtt       float64
+	rttvar     float64
+	rto        time.Duration
+	minRTO     time.Duration
+	cwnd       float64
+	ssthresh   float64
+	maxRetries int
+	sackBits   int
+	peerWindow int // last window advertised by the peer's ack, in packets
+}
+
+func newARQSender(opts sendOptions) *arqSender {
+	minRTO := durationFromSeconds(opts.minRTOms / 1000.0)
+	return &arqSender{
+		inflight:   make(map[uint64]*arqInflightPacket),
+		rto:        minRTO,
+		minRTO:     minRTO,
+		cwnd:       float64(opts.initCwnd),
+		ssthresh:   1 << 30,
+		maxRetries: opts.maxRetries,
+		sackBits:   opts.sackBits,
+		peerWindow: 1 << 30, // unconstrained until the first ack arrives
+	}
+}
+
+func (a *arqSender) onSend(seq uint64, payload []byte, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inflight[seq] = &arqInflightPacket{payload: payload, sendTime: now}
+}
+
+func (a *arqSender) inflightCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.inflight)
+}
+
+func (a *arqSender) hasInflight() bool {
+	return a.inflightCount() > 0
+}
+
+// cwndLimit returns how many packets may be in flight at once: the
+// congestion window, further capped by the receive window the peer last
+// advertised in its ack (so a slow/small sink can throttle the sender the
+// same way a full cwnd does).
+func (a *arqSender) cwndLimit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := int(a.cwnd)
+	if c < 1 {
+		c = 1
+	}
+	if a.peerWindow < c {
+		c = a.peerWindow
+	}
+	if c < 1 {
+		c = 1
+	}
+	return c
+}
+
+func (a *arqSender) updateRTT(sample time.Duration) {
+	s := sample.Seconds()
+	if a.srtt == 0 {
+		a.srtt = s
+		a.rttvar = s / 2
+	} else {
+		a.rttvar = 0.75*a.rttvar + 0.25*absFloat(a.srtt-s)
+		a.srtt = 7.0/8.0*a.srtt + 1.0/8.0*s
+	}
+	rto := durationFromSeconds(a.srtt + 4*a.rttvar)
+	if rto < a.minRTO {
+		rto = a.minRTO
+	}
+	a.rto = rto
+}
+
+func absFloat(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (a *arqSender) onLoss() {
+	a.ssthresh = a.cwnd / 2
+	if a.ssthresh < 1 {
+		a.ssthresh = 1
+	}
+	a.cwnd = a.ssthresh
+}
+
+func (a *arqSender) onAckGrowth(ackedPackets int) {
+	if a.cwnd < a.ssthresh {
+		a.cwnd += float64(ackedPackets)
+	} else {
+		a.cwnd += float64(ackedPackets) / a.cwnd
+	}
+}
+
+// handleAck retires acked/SACKed packets from the in-flight map, updates the
+// RTT/RTO estimate and congestion window, and returns any seqs that have
+// been SACKed-over three times and so qualify for fast retransmit.
+func (a *arqSender) handleAck(ack arqAckFrame, now time.Time) (ackedBytes int, ackedPackets int, retransmit []uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.peerWindow = int(ack.window)
+
+	for seq, pkt := range a.inflight {
+		if seq >= ack.cumAck {
+			continue
+		}
+		ackedBytes += len(pkt.payload)
+		ackedPackets++
+		if pkt.retries == 0 {
+			a.updateRTT(now.Sub(pkt.sendTime))
+		}
+		delete(a.inflight, seq)
+	}
+
+	highestSacked := ack.cumAck
+	for i := 0; i < a.sackBits; i++ {
+		if ack.sackBitmap&(1<<uint(i)) == 0 {
+			continue
+		}
+		seq := ack.cumAck + 1 + uint64(i)
+		if seq > highestSacked {
+			highestSacked = seq
+		}
+		pkt, ok := a.inflight[seq]
+		if !ok {
+			continue
+		}
+		ackedBytes += len(pkt.payload)
+		ackedPackets++
+		if pkt.retries == 0 {
+			a.updateRTT(now.Sub(pkt.sendTime))
+		}
+		delete(a.inflight, seq)
+	}
+
+	if ackedPackets > 0 {
+		a.onAckGrowth(ackedPackets)
+	}
+
+	for seq, pkt := range a.inflight {
+		if seq >= highestSacked {
+			continue
+		}
+		pkt.sackAdvances++
+		if pkt.sackAdvances == 3 && pkt.retries < a.maxRetries {
+			retransmit = append(retransmit, seq)
+		}
+	}
+	return ackedBytes, ackedPackets, retransmit
+}
+
+// checkTimeouts returns seqs whose RTO has elapsed (timedOut) and seqs that
+// have exhausted --max-retries and are being abandoned (giveUp).
+func (a *arqSender) checkTimeouts(now time.Time) (timedOut []uint64, giveUp []uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for seq, pkt := range a.inflight {
+		if now.Sub(pkt.sendTime) < a.rto {
+			continue
+		}
+		if pkt.retries >= a.maxRetries {
+			giveUp = append(giveUp, seq)
+			continue
+		}
+		timedOut = append(timedOut, seq)
+	}
+	if len(timedOut) > 0 || len(giveUp) > 0 {
+		a.onLoss()
+	}
+	for _, seq := range giveUp {
+		delete(a.inflight, seq)
+	}
+	return timedOut, giveUp
+}
+
+func (a *arqSender) markRetransmitted(seq uint64, now time.Time) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pkt, ok := a.inflight[seq]
+	if !ok {
+		return nil
+	}
+	pkt.retries++
+	pkt.sendTime = now
+	pkt.sackAdvances = 0
+	return pkt.payload
+}
+
+func runUDPSendARQ(opts sendOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
+	target := net.JoinHostPort(opts.target, strconv.Itoa(opts.port))
+	raddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return err
+	}
+
+	if opts.sndbufBytes > 0 {
+		_ = conn.SetWriteBuffer(opts.sndbufBytes)
+	}
+
+	fmt.Printf(
+		"udp send (reliability=udp-arq) target=%s:%d packet_size=%d count=%d duration_s=%.3f init_cwnd=%d min_rto_ms=%.1f\n",
+		opts.target, opts.port, opts.packetSize, opts.count, opts.durationS, opts.initCwnd, opts.minRTOms,
+	)
+
+	sender := newARQSender(opts)
+	sentStats := throughputStats{}
+	goodputStats := throughputStats{}
+	var retransmits int64
+
+	start := time.Now()
+	last := start
+	interval := durationFromSeconds(opts.reportIntervalS)
+	durationLimit := durationFromSeconds(opts.durationS)
+	labels := metricsLabels{role: "send", proto: "udp", flowID: strconv.FormatUint(uint64(opts.flowID), 10)}
+
+	ackDone := make(chan struct{})
+	go func() {
+		defer close(ackDone)
+		buf := make([]byte, 1500)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					if isStopped(stop) {
+						return
+					}
+					continue
+				}
+				return
+			}
+			ack, ok := parseARQAckFrame(buf[:n])
+			if !ok {
+				continue
+			}
+			now := time.Now()
+			ackedBytes, ackedPackets, retransmit := sender.handleAck(ack, now)
+			if ackedPackets > 0 {
+				goodputStats.addN(ackedBytes, ackedPackets)
+			}
+			tr.emit(traceEvent{TSNs: now.UnixNano(), Role: "send", Proto: "udp", FlowID: ack.flowID, Seq: ack.cumAck, Bytes: ackedBytes, Event: "ack"})
+			for _, seq := range retransmit {
+				if payload := sender.markRetransmitted(seq, now); payload != nil {
+					if _, err := conn.Write(payload); err == nil {
+						atomic.AddInt64(&retransmits, 1)
+						tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "send", Proto: "udp", FlowID: opts.flowID, Seq: seq, Bytes: len(payload), Event: "retx"})
+					}
+				}
+			}
+		}
+	}()
+
+	seq := uint64(1)
+	for {
+		if isStopped(stop) {
+			break
+		}
+		now := time.Now()
+		if durationLimit > 0 && now.Sub(start) >= durationLimit {
+			break
+		}
+		if opts.count > 0 && seq > uint64(opts.count) && !sender.hasInflight() {
+			break
+		}
+
+		timedOut, giveUp := sender.checkTimeouts(now)
+		for _, s := range giveUp {
+			tr.emit(traceEvent{TSNs: now.UnixNano(), Role: "send", Proto: "udp", FlowID: opts.flowID, Seq: s, Bytes: 0, Event: "drop"})
+		}
+		for _, s := range timedOut {
+			if payload := sender.markRetransmitted(s, now); payload != nil {
+				if _, err := conn.Write(payload); err != nil {
+					_ = conn.Close()
+					<-ackDone
+					return err
+				}
+				atomic.AddInt64(&retransmits, 1)
+				tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "send", Proto: "udp", FlowID: opts.flowID, Seq: s, Bytes: len(payload), Event: "retx"})
+			}
+		}
+
+		if (opts.count == 0 || seq <= uint64(opts.count)) && sender.inflightCount() < sender.cwndLimit() {
+			payload := buildARQDataFrame(opts.packetSize, opts.flowID, seq)
+			if _, err := conn.Write(payload); err != nil {
+				_ = conn.Close()
+				<-ackDone
+				return err
+			}
+			sender.onSend(seq, payload, now)
+			sentStats.add(len(payload))
+			tr.emit(traceEvent{TSNs: now.UnixNano(), Role: "send", Proto: "udp", FlowID: opts.flowID, Seq: seq, Bytes: len(payload), Event: "sent"})
+			seq++
+		} else {
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		if time.Since(last) >= interval {
+			last = reportARQSend("udp send", start, last, sentStats, goodputStats, atomic.LoadInt64(&retransmits), labels, mr)
+		}
+	}
+
+	graceDeadline := time.Now().Add(2 * sender.minRTO)
+	for sender.hasInflight() && time.Now().Before(graceDeadline) && !isStopped(stop) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = conn.Close()
+	<-ackDone
+
+	reportARQSend("udp send final", start, last, sentStats, goodputStats, atomic.LoadInt64(&retransmits), labels, mr)
+	return nil
+}
+
+func reportARQSend(prefix string, start time.Time, last time.Time, sent throughputStats, goodput throughputStats, retransmits int64, labels metricsLabels, mr *metricsRegistry) time.Time {
+	now := time.Now()
+	elapsed := now.Sub(start).Seconds()
+	intervalS := now.Sub(last).Seconds()
+	if elapsed < 1e-9 {
+		elapsed = 1e-9
+	}
+	if intervalS < 1e-9 {
+		intervalS = 1e-9
+	}
+	goodputMbps := (float64(goodput.bytes) * 8.0) / elapsed / 1_000_000.0
+	retxRate := 0.0
+	if sent.packets > 0 {
+		retxRate = float64(retransmits) / float64(sent.packets)
+	}
+	fmt.Printf(
+		"%s elapsed=%.3fs sent_packets=%d sent_bytes=%d acked_packets=%d goodput_mbps=%.3f retransmits=%d retx_rate=%.4f interval=%.3fs\n",
+		prefix, elapsed, sent.packets, sent.bytes, goodput.packets, goodputMbps, retransmits, retxRate, intervalS,
+	)
+	mr.observe(labels, sent, intervalS)
+	return now
+}
+
+func runUDPSinkARQ(opts sinkOptions, stop <-chan struct{}, tr *tracer, mr *metricsRegistry) error {
+	addr := net.JoinHostPort(opts.bind, strconv.Itoa(opts.port))
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if udpConn, ok := conn.(*net.UDPConn); ok && opts.rcvbufBytes > 0 {
+		_ = udpConn.SetReadBuffer(opts.rcvbufBytes)
+	}
+
+	const maxReorder = 1024
+	flows := make(map[uint32]*arqReorderState)
+	var duplicates int64
+
+	stats := throughputStats{}
+	start := time.Now()
+	last := start
+	interval := durationFromSeconds(opts.reportIntervalS)
+	durationLimit := durationFromSeconds(opts.durationS)
+	ackInterval := durationFromSeconds(opts.ackMS / 1000.0)
+	labels := metricsLabels{role: "sink", proto: "udp", flowID: "all"}
+
+	fmt.Printf("udp sink (reliability=udp-arq) listening on %s:%d\n", opts.bind, opts.port)
+	buf := make([]byte, opts.bufferSize)
+
+	sendAck := func(flowID uint32, fs *arqReorderState, to net.Addr, now time.Time) {
+		var bitmap uint32
+		for i := 0; i < opts.sackBits; i++ {
+			if fs.received[fs.rcvNxt+1+uint64(i)] {
+				bitmap |= 1 << uint(i)
+			}
+		}
+		window := uint16(maxReorder - len(fs.received))
+		ack := buildARQAckFrame(flowID, fs.rcvNxt, bitmap, window)
+		_, _ = conn.WriteTo(ack, to)
+		tr.emit(traceEvent{TSNs: now.UnixNano(), Role: "sink", Proto: "udp", FlowID: flowID, Seq: fs.rcvNxt, Bytes: len(ack), Event: "ack"})
+		fs.lastAck = now
+		fs.dirty = false
+	}
+
+	// flushDueAcks sends a coalesced ack for every flow whose pending
+	// (unacked) state is older than ackInterval, so a flow that falls quiet
+	// still gets its cumulative/SACK ack flushed within --ack-ms.
+	flushDueAcks := func(now time.Time) {
+		for flowID, fs := range flows {
+			if fs.dirty && now.Sub(fs.lastAck) >= ackInterval && fs.addr != nil {
+				sendAck(flowID, fs, fs.addr, now)
+			}
+		}
+	}
+
+	for {
+		if isStopped(stop) {
+			break
+		}
+		now := time.Now()
+		if durationLimit > 0 && now.Sub(start) >= durationLimit {
+			break
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(ackInterval))
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return err
+			}
+			flushDueAcks(time.Now())
+		} else if frame, ok := parseARQDataFrame(buf[:n]); ok {
+			fs := flowReorderState(flows, frame.flowID)
+			fs.addr = from
+			switch {
+			case frame.seq == fs.rcvNxt:
+				fs.rcvNxt++
+				for fs.received[fs.rcvNxt] {
+					delete(fs.received, fs.rcvNxt)
+					fs.rcvNxt++
+				}
+				stats.add(n)
+			case frame.seq > fs.rcvNxt:
+				if !fs.received[frame.seq] {
+					fs.received[frame.seq] = true
+					stats.add(n)
+				} else {
+					duplicates++
+				}
+			default:
+				duplicates++
+			}
+			fs.dirty = true
+			tr.emit(traceEvent{TSNs: time.Now().UnixNano(), Role: "sink", Proto: "udp", FlowID: frame.flowID, Seq: frame.seq, Bytes: n, Event: "recv"})
+			mr.observeOWD(float64(time.Now().UnixNano()-int64(frame.sendTS)) / 1e9)
+			ackNow := time.Now()
+			if fs.lastAck.IsZero() || ackNow.Sub(fs.lastAck) >= ackInterval {
+				sendAck(frame.flowID, fs, from, ackNow)
+			}
+		}
+		flushDueAcks(time.Now())
+
+		if time.Since(last) >= interval {
+			last = reportARQSink("udp sink", start, last, stats, duplicates, labels, mr)
+		}
+	}
+
+	reportARQSink("udp sink final", start, last, stats, duplicates, labels, mr)
+	return nil
+}
+
+// arqReorderState is the per-flow sequence/reorder-buffer state for a
+// udp-arq sink; keyed by flow id so multiple concurrent udp-arq senders
+// (each with its own --flow-id) sharing one sink don't corrupt each other's
+// cumulative ack, matching how the plain (non-ARQ) sink paths key perFlow
+// stats via parsePayloadHeader.
+type arqReorderState struct {
+	rcvNxt   uint64
+	received map[uint64]bool
+	addr     net.Addr // last known peer address, used to flush a coalesced ack
+	lastAck  time.Time
+	dirty    bool // true when rcvNxt/received changed since the last ack sent
+}
+
+// flowReorderState returns the arqReorderState for flowID, creating it (with
+// rcvNxt starting at 1) on first use.
+func flowReorderState(flows map[uint32]*arqReorderState, flowID uint32) *arqReorderState {
+	fs, ok := flows[flowID]
+	if !ok {
+		fs = &arqReorderState{rcvNxt: 1, received: make(map[uint64]bool)}
+		flows[flowID] = fs
+	}
+	return fs
+}
+
+func reportARQSink(prefix string, start time.Time, last time.Time, stats throughputStats, duplicates int64, labels metricsLabels, mr *metricsRegistry) time.Time {
+	now := time.Now()
+	elapsed := now.Sub(start).Seconds()
+	intervalS := now.Sub(last).Seconds()
+	if elapsed < 1e-9 {
+		elapsed = 1e-9
+	}
+	if intervalS < 1e-9 {
+		intervalS = 1e-9
+	}
+	goodputMbps := (float64(stats.bytes) * 8.0) / elapsed / 1_000_000.0
+	retxRate := 0.0
+	if stats.packets+duplicates > 0 {
+		retxRate = float64(duplicates) / float64(stats.packets+duplicates)
+	}
+	fmt.Printf(
+		"%s elapsed=%.3fs packets=%d bytes=%d goodput_mbps=%.3f duplicates=%d retx_rate=%.4f interval=%.3fs\n",
+		prefix, elapsed, stats.packets, stats.bytes, goodputMbps, duplicates, retxRate, intervalS,
+	)
+	mr.observe(labels, stats, intervalS)
+	return now
+}
+
+// flowStats returns the throughputStats for flowID in perFlow, creating it
+// on first use.
+func flowStats(perFlow map[uint32]*throughputStats, flowID uint32) *throughputStats {
+	ps, ok := perFlow[flowID]
+	if !ok {
+		ps = &throughputStats{}
+		perFlow[flowID] = ps
+	}
+	return ps
+}
+
+// reportPerFlow prints one report() line per flow, in ascending flow id
+// order, alongside the aggregate sink report.
+func reportPerFlow(prefix string, start time.Time, last time.Time, perFlow map[uint32]*throughputStats, role string, proto string, mr *metricsRegistry) {
+	ids := make([]uint32, 0, len(perFlow))
+	for id := range perFlow {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		labels := metricsLabels{role: role, proto: proto, flowID: strconv.FormatUint(uint64(id), 10)}
+		report(fmt.Sprintf("%s flow=%d", prefix, id), start, last, *perFlow[id], labels, mr)
+	}
+}
+
+func report(prefix string, start time.Time, last time.Time, stats throughputStats, labels metricsLabels, mr *metricsRegistry) time.Time {
 	now := time.Now()
 	elapsed := now.Sub(start).Seconds()
 	interval := now.Sub(last).Seconds()
@@ -680,6 +1949,7 @@ func report(prefix string, start time.Time, last time.Time, stats throughputStat
 		avgMbps,
 		interval,
 	)
+	mr.observe(labels, stats, interval)
 	return now
 }
 
@@ -699,12 +1969,22 @@ func printUsage() {
 	fmt.Println("  --buffer-size 65535 --report-interval-s 1")
 	fmt.Println("  --listen-backlog 8 --rcvbuf-bytes 0")
 	fmt.Println("  --duration-s 0 --start-after-s 0")
+	fmt.Println("  --reliability none|udp-arq --ack-ms 10 --sack-bits 32")
+	fmt.Println("  --trace-jsonl <path>|- (per-packet JSONL trace)")
+	fmt.Println("  --metrics-listen <host:port> (Prometheus /metrics endpoint)")
 	fmt.Println("")
 	fmt.Println("send options:")
 	fmt.Println("  --proto udp|tcp --target <ip> --port <port>")
 	fmt.Println("  --packet-size 256 --count 1 --duration-s 0 --pps 0")
-	fmt.Println("  --pattern bulk|onoff --on-ms 2000 --off-ms 1000")
+	fmt.Println("  --pattern bulk|onoff|poisson|mmpp --on-ms 2000 --off-ms 1000")
+	fmt.Println("  --seed 0 --mmpp-lambda1 50 --mmpp-lambda2 5")
+	fmt.Println("  --mmpp-mean1-ms 500 --mmpp-mean2-ms 500")
 	fmt.Println("  --flow-id 1 --report-interval-s 1")
 	fmt.Println("  --connect-timeout-s 3 --sndbuf-bytes 0 --tcp-nodelay")
 	fmt.Println("  --start-after-s 0")
+	fmt.Println("  --reliability none|udp-arq (udp only)")
+	fmt.Println("  --init-cwnd 4 --min-rto-ms 200 --max-retries 10 --sack-bits 32")
+	fmt.Println("  --trace-jsonl <path>|- (per-packet JSONL trace)")
+	fmt.Println("  --streams 1 (parallel flows, each base --flow-id + index)")
+	fmt.Println("  --metrics-listen <host:port> (Prometheus /metrics endpoint)")
 }
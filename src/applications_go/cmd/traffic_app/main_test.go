@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExponentialInterval(t *testing.T) {
+	if got := exponentialInterval(rand.New(rand.NewSource(1)), 0); got != 0 {
+		t.Fatalf("lambda<=0: got %v, want 0", got)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		if got := exponentialInterval(rng, 10); got < 0 {
+			t.Fatalf("exponentialInterval returned negative duration: %v", got)
+		}
+	}
+}
+
+func TestClampToOnWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		ts         time.Time
+		onDuration time.Duration
+		offDur     time.Duration
+		wantOK     bool
+		wantTS     time.Time
+	}{
+		{
+			name:       "always off rejects",
+			ts:         start,
+			onDuration: 0,
+			offDur:     time.Second,
+			wantOK:     false,
+		},
+		{
+			name:       "zero cycle passes through",
+			ts:         start.Add(5 * time.Second),
+			onDuration: 0,
+			offDur:     0,
+			wantOK:     true,
+			wantTS:     start.Add(5 * time.Second),
+		},
+		{
+			name:       "inside on-window passes through",
+			ts:         start.Add(500 * time.Millisecond),
+			onDuration: time.Second,
+			offDur:     time.Second,
+			wantOK:     true,
+			wantTS:     start.Add(500 * time.Millisecond),
+		},
+		{
+			name:       "inside off-window defers to next on-window",
+			ts:         start.Add(1500 * time.Millisecond),
+			onDuration: time.Second,
+			offDur:     time.Second,
+			wantOK:     true,
+			wantTS:     start.Add(2 * time.Second),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := clampToOnWindow(tc.ts, start, tc.onDuration, tc.offDur)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if ok && !got.Equal(tc.wantTS) {
+				t.Fatalf("ts = %v, want %v", got, tc.wantTS)
+			}
+		})
+	}
+}
+
+func TestComputeNextSendTime(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(time.Second)
+
+	t.Run("bulk unpaced returns now", func(t *testing.T) {
+		opts := sendOptions{pattern: modeBulk}
+		got, ok := computeNextSendTime(opts, start, now, time.Time{}, 0, 0)
+		if !ok || !got.Equal(now) {
+			t.Fatalf("got (%v, %v), want (%v, true)", got, ok, now)
+		}
+	})
+
+	t.Run("bulk paced waits for nextSend", func(t *testing.T) {
+		opts := sendOptions{pattern: modeBulk, pps: 10}
+		nextSend := now.Add(2 * time.Second)
+		got, ok := computeNextSendTime(opts, start, now, nextSend, 0, 0)
+		if !ok || !got.Equal(nextSend) {
+			t.Fatalf("got (%v, %v), want (%v, true)", got, ok, nextSend)
+		}
+	})
+
+	t.Run("onoff rejects when always off", func(t *testing.T) {
+		opts := sendOptions{pattern: modeOnOff}
+		_, ok := computeNextSendTime(opts, start, now, time.Time{}, 0, time.Second)
+		if ok {
+			t.Fatalf("expected onoff with onDuration=0 to be rejected")
+		}
+	})
+
+	t.Run("onoff inside on-window passes through", func(t *testing.T) {
+		opts := sendOptions{pattern: modeOnOff}
+		nowInWindow := start.Add(200 * time.Millisecond)
+		got, ok := computeNextSendTime(opts, start, nowInWindow, time.Time{}, time.Second, time.Second)
+		if !ok || !got.Equal(nowInWindow) {
+			t.Fatalf("got (%v, %v), want (%v, true)", got, ok, nowInWindow)
+		}
+	})
+}
+
+func newTestARQSender() *arqSender {
+	return newARQSender(sendOptions{
+		minRTOms:   10,
+		initCwnd:   8,
+		maxRetries: 5,
+		sackBits:   32,
+	})
+}
+
+func TestArqSenderHandleAckCumulativeAndSACK(t *testing.T) {
+	a := newTestARQSender()
+	now := time.Now()
+	for seq := uint64(1); seq <= 4; seq++ {
+		a.onSend(seq, make([]byte, 10), now)
+	}
+
+	// cumAck=2 acks seq1; sackBitmap bit 1 (cumAck+1+1=4) acks seq4.
+	ack := arqAckFrame{cumAck: 2, sackBitmap: 1 << 1, window: 100}
+	ackedBytes, ackedPackets, _ := a.handleAck(ack, now.Add(time.Millisecond))
+	if ackedPackets != 2 {
+		t.Fatalf("ackedPackets = %d, want 2", ackedPackets)
+	}
+	if ackedBytes != 20 {
+		t.Fatalf("ackedBytes = %d, want 20", ackedBytes)
+	}
+	if a.inflightCount() != 2 {
+		t.Fatalf("inflightCount = %d, want 2 (seq2, seq3 remaining)", a.inflightCount())
+	}
+}
+
+func TestArqSenderFastRetransmitAfterThreeSACKAdvances(t *testing.T) {
+	a := newTestARQSender()
+	now := time.Now()
+	for seq := uint64(1); seq <= 4; seq++ {
+		a.onSend(seq, make([]byte, 10), now)
+	}
+
+	// cumAck=2 acks seq1 once; sackBitmap bit 1 (cumAck+1+1=4) acks seq4;
+	// seq2/seq3 sit below the highest SACKed seq (4) so each call advances
+	// their sackAdvances counter.
+	ack := arqAckFrame{cumAck: 2, sackBitmap: 1 << 1, window: 100}
+	var retransmit []uint64
+	for i := 0; i < 3; i++ {
+		_, _, retransmit = a.handleAck(ack, now.Add(time.Duration(i+1)*time.Millisecond))
+	}
+
+	got := append([]uint64{}, retransmit...)
+	sortUint64s(got)
+	want := []uint64{2, 3}
+	if !equalUint64s(got, want) {
+		t.Fatalf("retransmit = %v, want %v", got, want)
+	}
+}
+
+func TestArqSenderCheckTimeouts(t *testing.T) {
+	a := newTestARQSender()
+	a.maxRetries = 1
+	now := time.Now()
+	a.onSend(1, make([]byte, 10), now)
+
+	timedOut, giveUp := a.checkTimeouts(now.Add(a.minRTO + time.Millisecond))
+	if len(giveUp) != 0 || len(timedOut) != 1 || timedOut[0] != 1 {
+		t.Fatalf("first check: timedOut=%v giveUp=%v, want timedOut=[1] giveUp=[]", timedOut, giveUp)
+	}
+
+	a.markRetransmitted(1, now.Add(a.minRTO+time.Millisecond))
+	_, giveUp = a.checkTimeouts(now.Add(2*a.minRTO + 2*time.Millisecond))
+	if len(giveUp) != 1 || giveUp[0] != 1 {
+		t.Fatalf("second check: giveUp=%v, want [1] once retries reach maxRetries", giveUp)
+	}
+	if a.hasInflight() {
+		t.Fatalf("expected seq1 to be dropped from inflight after giving up")
+	}
+}
+
+func TestArqSenderCwndLimitCapsToPeerWindow(t *testing.T) {
+	a := newTestARQSender()
+	a.cwnd = 50
+	a.handleAck(arqAckFrame{cumAck: 1, window: 3}, time.Now())
+	if got := a.cwndLimit(); got != 3 {
+		t.Fatalf("cwndLimit() = %d, want 3 (capped by peer window)", got)
+	}
+}
+
+func newTestMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		packets:    make(map[metricsLabels]int64),
+		bytes:      make(map[metricsLabels]int64),
+		interval:   make(map[metricsLabels]float64),
+		owdBuckets: make([]int64, len(owdBucketsSeconds)),
+	}
+}
+
+func TestMetricsRegistryWriteText(t *testing.T) {
+	m := newTestMetricsRegistry()
+	m.observe(metricsLabels{role: "send", proto: "udp", flowID: "1"}, throughputStats{packets: 5, bytes: 500}, 1.0)
+	m.observeOWD(0.02)
+
+	var buf bytes.Buffer
+	m.writeText(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		`romam_packets_total{role="send",proto="udp",flow_id="1"} 5`,
+		`romam_bytes_total{role="send",proto="udp",flow_id="1"} 500`,
+		`romam_owd_seconds_count 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("writeText output missing %q; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsRegistryObserveOWDBuckets(t *testing.T) {
+	m := newTestMetricsRegistry()
+	m.observeOWD(0.5) // falls in the le=0.5 bucket
+
+	var buf bytes.Buffer
+	m.writeText(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, `romam_owd_seconds_bucket{le="0.5"} 1`) {
+		t.Fatalf("expected le=0.5 bucket to have cumulative count 1; got:\n%s", out)
+	}
+	if !strings.Contains(out, `romam_owd_seconds_bucket{le="+Inf"} 1`) {
+		t.Fatalf("expected +Inf bucket to have count 1; got:\n%s", out)
+	}
+}
+
+func sortUint64s(s []uint64) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j-1] > s[j]; j-- {
+			s[j-1], s[j] = s[j], s[j-1]
+		}
+	}
+}
+
+func equalUint64s(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}